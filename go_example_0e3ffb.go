@@ -8,7 +8,25 @@
 // (for O(1) recency updates and O(1) eviction of the least recently used item).
 
 import (
-	"sync" // The sync package provides synchronization primitives like Mutex.
+	"bytes"          // The bytes package provides an in-memory io.Reader over cached bodies.
+	"container/heap" // The heap package keeps the most frequently accessed keys ordered.
+	"context"        // The context package bounds how long Close waits for in-flight loads.
+	"encoding/gob"   // The gob package provides Go's native binary serialization, used for persistence.
+	"errors"         // The errors package creates simple error values.
+	"fmt"            // The fmt package formats error messages.
+	"hash/fnv"       // The fnv package provides a stable hash for content fingerprints.
+	"hash/maphash"   // The maphash package hashes arbitrary comparable keys for the access sketch.
+	"io"             // The io package provides the Reader and Writer interfaces.
+	"io/ioutil"      // The ioutil package provides helpers for reading whole bodies.
+	"log"            // The log package reports misbehaving user hooks without crashing.
+	"math"           // The math package provides the logarithm used to estimate distinct keys.
+	"math/rand"      // The rand package picks victims for the weighted-random eviction policy.
+	"net/http"       // The http package lets the cache sit behind an http.Client as a RoundTripper.
+	"sort"           // The sort package orders entries for listing.
+	"strconv"        // The strconv package parses Cache-Control max-age values.
+	"strings"        // The strings package helps inspect Cache-Control directives.
+	"sync"           // The sync package provides synchronization primitives like Mutex.
+	"time"           // The time package seeds the default random source and expires cached responses.
 )
 
 // cacheEntry represents an entry in the LRU cache's doubly linked list.
@@ -16,10 +34,23 @@ import (
 // K is the type of the key, which must be 'comparable' (e.g., int, string, structs that support == and !=).
 // V is the type of the value, which can be 'any' type.
 type cacheEntry[K comparable, V any] struct {
-	key   K
-	value V
-	prev  *cacheEntry[K, V] // Pointer to the previous entry in the list.
-	next  *cacheEntry[K, V] // Pointer to the next entry in the list.
+	key    K
+	value  V
+	prev   *cacheEntry[K, V] // Pointer to the previous entry in the list.
+	next   *cacheEntry[K, V] // Pointer to the next entry in the list.
+	tags   []string          // Tags attached with PutWithTags, mirrored in LRUCache.tagIndex.
+	seq    uint64            // Insertion sequence number, set on first insert and kept across updates.
+	refs   int               // Number of outstanding Acquire borrows; a borrowed entry stays counted in size.
+	doomed bool              // Set when the entry was evicted or removed while borrowed; uncounted on last release.
+	hits   uint64            // Number of read hits on this entry, used by the weighted-random policy and HitCountsTopN.
+	ver    uint64            // Write version: 1 on insert, incremented by every update.
+	prio   Priority          // Eviction tier; lower tiers are evicted first. See PutWithPriority.
+}
+
+// Entry is an exported copy of a cached key/value pair, returned by methods that list contents.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
 }
 
 // LRUCache implements the LRU cache functionality.
@@ -31,15 +62,35 @@ type LRUCache[K comparable, V any] struct {
 	cacheMap map[K]*cacheEntry[K, V]       // Maps keys to their corresponding cache entries for O(1) lookup.
 	head     *cacheEntry[K, V]             // Pointer to the most recently used entry (front of the list).
 	tail     *cacheEntry[K, V]             // Pointer to the least recently used entry (back of the list).
+	tagIndex map[string]map[K]struct{}     // Reverse index from tag to the keys carrying it.
+	pending  map[K]*pendingCreate[V]       // Keys whose value is being created by LoadOrStoreFunc.
+	loads    sync.WaitGroup                // Counts in-flight LoadOrStoreFunc creations, for Close to wait on.
+	closed   bool                          // Set by Close; no new creations are tracked afterwards.
+	nextSeq  uint64                        // Sequence number handed to the next inserted entry.
+	deferred int                           // Number of doomed entries still counted in size, awaiting release.
+	epoch    uint64                        // Bumped by ImportGob, so releases of the replaced contents' entries are ignored.
+	access   *accessTracker[K]             // Keyspace access statistics; nil unless access tracking is enabled.
+	policy   EvictionPolicy                // How eviction victims are chosen; EvictLRU by default.
+	rng      *rand.Rand                    // Random source for the EvictWeightedRandom policy.
+	veto     func(key K, value V) bool     // Optional hook that can spare an entry from eviction.
+	preserve bool                          // CacheConfig.PreserveOnDisable: resizing to 0 fills spill instead of discarding.
+	spill    []Entry[K, V]                 // Contents set aside by a preserving resize to 0, MRU first.
+	marks    []*watermark                  // Utilization thresholds registered with OnWatermark.
+	tiered   int                           // Number of entries whose priority isn't PriorityNormal.
+	opLog    []OpRecord[K]                 // Ring buffer of recent operations; nil when the operation log is disabled.
+	opNext   int                           // Index in opLog where the next operation will be written.
+	opCount  int                           // Number of valid records in opLog (at most len(opLog)).
 	mutex    sync.Mutex                    // A mutex to protect all shared data (cacheMap, head, tail, size) from concurrent access.
 }
 
 // NewLRUCache creates and initializes a new LRUCache with the given capacity.
 // It uses generic type parameters K and V, making the cache reusable for any comparable key type
 // and any value type.
+// A capacity of 0 creates a disabled cache: every method is safe to call, but Put stores nothing
+// and Get always misses. This lets callers switch caching off without special-casing call sites.
 func NewLRUCache[K comparable, V any](capacity int) *LRUCache[K, V] {
-	if capacity <= 0 {
-		panic("Cache capacity must be greater than 0") // An LRU cache needs a positive capacity to function.
+	if capacity < 0 {
+		panic("Cache capacity must not be negative") // 0 means disabled; anything below is a bug.
 	}
 	return &LRUCache[K, V]{
 		capacity: capacity,
@@ -55,10 +106,16 @@ func (c *LRUCache[K, V]) Get(key K) (V, bool) {
 	c.mutex.Lock()         // Acquire the lock to ensure thread safety before accessing shared data.
 	defer c.mutex.Unlock() // Release the lock when the function exits, guaranteeing it's always unlocked.
 
+	if c.access != nil {
+		c.access.record(key) // Feed the keyspace analysis, hit or miss.
+	}
 	if entry, found := c.cacheMap[key]; found {
 		c.moveToFront(entry) // This entry was just accessed, so it's now the MRU item.
+		entry.hits++
+		c.recordOp("get", key, "hit")
 		return entry.value, true
 	}
+	c.recordOp("get", key, "miss")
 	var zeroValue V // Declare a variable of type V to get its zero value.
 	return zeroValue, false
 }
@@ -71,24 +128,56 @@ func (c *LRUCache[K, V]) Put(key K, value V) {
 	c.mutex.Lock()         // Acquire the lock for thread safety.
 	defer c.mutex.Unlock() // Release the lock.
 
+	c.put(key, value)
+}
+
+// put implements Put and returns the stored entry, or nil if the cache is disabled. It must be called with the mutex held,
+// which lets other methods combine a Put with their own bookkeeping in one critical section.
+// An existing entry keeps its eviction priority; a new one gets PriorityNormal.
+func (c *LRUCache[K, V]) put(key K, value V) *cacheEntry[K, V] {
+	prio := PriorityNormal
+	if entry, found := c.cacheMap[key]; found {
+		prio = entry.prio
+	}
+	return c.putWithPriority(key, value, prio)
+}
+
+// putWithPriority is put with an explicit eviction priority, which is also applied to an existing
+// entry. Setting it before evicting matters: a new low-priority entry may itself be the victim.
+func (c *LRUCache[K, V]) putWithPriority(key K, value V, prio Priority) *cacheEntry[K, V] {
+	if c.capacity == 0 {
+		return nil // Disabled cache: nothing is ever stored.
+	}
 	if entry, found := c.cacheMap[key]; found {
 		// Key already exists: update its value and move it to the front (MRU).
 		entry.value = value
+		c.setPriority(entry, prio)
+		entry.ver++ // Every write bumps the version, so PutIfVersion can detect lost updates.
 		c.moveToFront(entry)
-		return
+		c.recordOp("put", key, "update")
+		return entry
 	}
 
 	// Key does not exist: create a new entry.
-	newEntry := &cacheEntry[K, V]{key: key, value: value}
+	newEntry := &cacheEntry[K, V]{key: key, value: value, seq: c.nextSeq, ver: 1}
+	c.nextSeq++ // Monotonic, so sorting by seq gives insertion order.
+	c.setPriority(newEntry, prio)
 	c.cacheMap[key] = newEntry // Add the new entry to the map for quick lookups.
 	c.addFront(newEntry)       // Add the new entry to the front of the list (it's the new MRU).
 	c.size++                   // Increment the cache's current size.
+	c.recordOp("put", key, "insert")
 
 	// Check if the cache has exceeded its capacity.
-	if c.size > c.capacity {
+	// Usually one eviction suffices; more are needed only if the cache had grown past its
+	// capacity because every entry was vetoed, and none are possible while that's still the case.
+	for c.overCapacity() {
 		// Capacity exceeded: remove the least recently used item (from the tail).
-		c.removeTail()
+		if !c.removeTail() {
+			break
+		}
 	}
+	c.checkWatermarks()
+	return newEntry
 }
 
 // --- Doubly Linked List Helper Functions (internal to the LRUCache logic) ---
@@ -139,16 +228,1413 @@ func (c *LRUCache[K, V]) addFront(entry *cacheEntry[K, V]) {
 
 // removeTail removes the least recently used entry (the one at the tail) from the cache.
 // This is called when the cache capacity is exceeded, performing the LRU eviction.
-func (c *LRUCache[K, V]) removeTail() {
-	if c.tail == nil {
-		return // Nothing to remove if the cache is empty.
+// (Under the EvictWeightedRandom policy the victim is drawn at random instead; see pickVictim.)
+// Entries that are currently borrowed through Acquire are skipped, like vetoed ones.
+// It reports whether an entry was evicted; false means no entry was evictable.
+func (c *LRUCache[K, V]) removeTail() bool {
+	victim := c.pickVictim()
+	if victim == nil {
+		return false // Nothing to remove if the cache is empty (or every entry is borrowed or vetoed).
+	}
+	c.deleteEntry(victim)
+	c.recordOp("evict", victim.key, "evicted")
+	return true
+}
+
+// pickVictim chooses the entry to evict according to the eviction policy. Only evictable entries
+// are chosen (see evictable); if there are none it returns nil and the cache grows past its capacity.
+// Priority tiers are tried from low to high, and the policy picks among the lowest tier that has
+// an evictable entry, so a higher tier is only touched once every lower tier is exhausted.
+func (c *LRUCache[K, V]) pickVictim() *cacheEntry[K, V] {
+	for prio := PriorityLow; prio <= PriorityHigh; prio++ {
+		if prio != PriorityNormal && c.tiered == 0 {
+			continue // PutWithPriority isn't in use, so every entry is in the normal tier.
+		}
+		if victim := c.pickFromTier(prio); victim != nil {
+			return victim
+		}
+	}
+	return nil
+}
+
+// pickFromTier applies the eviction policy to the entries of priority prio only.
+func (c *LRUCache[K, V]) pickFromTier(prio Priority) *cacheEntry[K, V] {
+	if c.policy == EvictWeightedRandom {
+		return c.pickWeightedRandom(prio)
+	}
+	for entry := c.tail; entry != nil; entry = entry.prev {
+		if entry.prio == prio && c.evictable(entry) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// pickWeightedRandom draws a victim among the entries of priority prio with probability inversely
+// proportional to its hit count (plus one, so never-read entries have the largest weight). It walks
+// the whole list, so eviction costs O(n) under this policy rather than O(1).
+func (c *LRUCache[K, V]) pickWeightedRandom(prio Priority) *cacheEntry[K, V] {
+	var candidates []*cacheEntry[K, V]
+	total := 0.0
+	for entry := c.head; entry != nil; entry = entry.next {
+		if entry.prio == prio && c.evictable(entry) { // Ask the veto hook once per entry, then draw among the rest.
+			candidates = append(candidates, entry)
+			total += 1 / float64(entry.hits+1)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	target := c.rng.Float64() * total
+	for _, entry := range candidates {
+		target -= 1 / float64(entry.hits+1)
+		if target < 0 {
+			return entry
+		}
+	}
+	return candidates[len(candidates)-1] // Guards against floating-point rounding leaving target just above zero.
+}
+
+// evictable reports whether entry may be chosen as an eviction victim. Entries borrowed through
+// Acquire and vetoed entries are spared.
+func (c *LRUCache[K, V]) evictable(entry *cacheEntry[K, V]) bool {
+	return entry.refs == 0 && !c.vetoed(entry)
+}
+
+// vetoed consults the eviction veto hook, if any. A panicking hook is logged and treated as not
+// vetoing, so a buggy hook can't make the cache grow without bound or crash the caller.
+func (c *LRUCache[K, V]) vetoed(entry *cacheEntry[K, V]) (veto bool) {
+	if c.veto == nil {
+		return false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("cache eviction veto panicked for key %v: %v", entry.key, r)
+			veto = false
+		}
+	}()
+	return c.veto(entry.key, entry.value)
+}
+
+// SetEvictionVeto installs a hook consulted before an entry is evicted to make room; returning
+// true spares the entry, and the next eligible entry (in policy order) is evicted instead. If every
+// entry is vetoed, nothing is evicted and the cache temporarily grows past its capacity until
+// entries become evictable again. Explicit removals are not subject to the veto. The hook runs
+// with the cache locked and must not call back into the cache; pass nil to remove it.
+func (c *LRUCache[K, V]) SetEvictionVeto(veto func(key K, value V) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.veto = veto
+}
+
+// overCapacity reports whether the cache holds more live entries than its capacity allows.
+// Doomed entries are excluded: they are already on their way out.
+func (c *LRUCache[K, V]) overCapacity() bool {
+	return c.size-c.deferred > c.capacity
+}
+
+// dropEntry deletes entry for an explicit removal (eviction never picks borrowed entries). If it
+// is currently borrowed it is still removed from the list, the map and the tag index right away,
+// so lookups miss from now on, but it is marked doomed and stays counted in size until its last
+// release. It reports whether the entry was fully deleted.
+func (c *LRUCache[K, V]) dropEntry(entry *cacheEntry[K, V]) bool {
+	c.deleteEntry(entry)
+	if entry.refs > 0 {
+		entry.doomed = true
+		c.size++ // Still held by a borrower; see Acquire.
+		c.deferred++
+		return false
+	}
+	return true
+}
+
+// deleteEntry removes an entry from both the linked list and the map and updates the size.
+// Every path that drops an entry from the cache goes through here.
+func (c *LRUCache[K, V]) deleteEntry(entry *cacheEntry[K, V]) {
+	c.remove(entry)                      // Unlink it from the recency list.
+	delete(c.cacheMap, entry.key)        // Remove the entry from the map using its key.
+	c.size--                             // Decrement the cache's current size.
+	c.untag(entry)                       // Drop it from the tag index so InvalidateTag won't see it.
+	c.setPriority(entry, PriorityNormal) // Keep the tiered count in step.
+	c.checkWatermarks()                  // Utilization dropped; this may re-arm watermarks.
+}
+
+// Replace updates the value for key only if key is already cached, promoting it to most recently
+// used, and reports whether it did. Unlike Put it never inserts, so a missing key stays missing.
+func (c *LRUCache[K, V]) Replace(key K, value V) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, found := c.cacheMap[key]; !found {
+		return false
+	}
+	c.put(key, value) // Takes the update path: new value, moved to the front.
+	return true
+}
+
+// Update atomically replaces the value for key with fn(current value), promoting the entry to most
+// recently used, and reports whether key was present; if it wasn't, fn is not called. Because the
+// read, fn and the write all happen under one lock, concurrent Updates never lose each other's
+// changes the way a Get followed by a Put can. fn runs with the cache locked, so it should be quick
+// and must not call back into the cache. If V is a slice or map, fn should return a modified copy
+// rather than change the old value in place, since earlier Get callers may still be using it.
+func (c *LRUCache[K, V]) Update(key K, fn func(V) V) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, found := c.cacheMap[key]
+	if !found {
+		return false
+	}
+	c.put(key, fn(entry.value)) // Takes the update path, like Replace.
+	return true
+}
+
+// GetWithVersion is like Get but also returns the entry's write version, for use with
+// PutIfVersion. The version of an absent key is 0.
+func (c *LRUCache[K, V]) GetWithVersion(key K) (V, uint64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry, found := c.cacheMap[key]; found {
+		c.moveToFront(entry)
+		entry.hits++
+		c.recordOp("get", key, "hit")
+		return entry.value, entry.ver, true
+	}
+	c.recordOp("get", key, "miss")
+	var zeroValue V
+	return zeroValue, 0, false
+}
+
+// PutIfVersion stores value only if the entry's current write version equals expectedVersion
+// (0 meaning the key must be absent), implementing optimistic concurrency: read with
+// GetWithVersion, compute, then write back with the version you read. If another writer got in
+// first the versions no longer match and the write is rejected instead of silently lost.
+// It returns the entry's version after the call and whether the value was stored.
+// Note that a key which is evicted and re-inserted starts again at version 1.
+func (c *LRUCache[K, V]) PutIfVersion(key K, value V, expectedVersion uint64) (uint64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var current uint64
+	if entry, found := c.cacheMap[key]; found {
+		current = entry.ver
+	}
+	if current != expectedVersion {
+		return current, false // Someone else wrote in between: the caller must re-read and retry.
+	}
+	entry := c.put(key, value)
+	if entry == nil {
+		return 0, false // Disabled cache: nothing can be stored.
+	}
+	return entry.ver, true
+}
+
+// RemoveMany removes every key in keys that is present in the cache, all under a single lock
+// acquisition, and returns how many entries were removed. Absent keys are ignored, and the
+// relative recency of the surviving entries is not affected.
+func (c *LRUCache[K, V]) RemoveMany(keys []K) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	removed := 0
+	for _, key := range keys {
+		if entry, found := c.cacheMap[key]; found {
+			c.dropEntry(entry) // Borrowed entries stay counted in size until released.
+			c.recordOp("remove", key, "removed")
+			removed++
+		}
+	}
+	return removed
+}
+
+// EntriesByInsertion returns a copy of every entry ordered by when its key was first inserted,
+// oldest first. Unlike the recency order, this order is not affected by Gets or by updating an
+// existing key; a key that is removed and inserted again counts as newly inserted.
+func (c *LRUCache[K, V]) EntriesByInsertion() []Entry[K, V] {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ordered := make([]*cacheEntry[K, V], 0, c.size)
+	for entry := c.head; entry != nil; entry = entry.next {
+		ordered = append(ordered, entry)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].seq < ordered[j].seq })
+
+	entries := make([]Entry[K, V], len(ordered))
+	for i, entry := range ordered {
+		entries[i] = Entry[K, V]{Key: entry.key, Value: entry.value}
+	}
+	return entries
+}
+
+// Acquire borrows the entry for key, returning its value and a release function. While at least
+// one borrow is outstanding the entry is never evicted: eviction skips it and picks the next
+// victim, and if every entry is borrowed the cache temporarily grows past its capacity, shrinking
+// back once the last borrower calls release. This suits values backing live resources, such as
+// open handles, that must not be closed while in use. An explicit removal (RemoveMany,
+// InvalidateTag) still takes the entry out of the cache at once, so lookups miss, but it stays
+// counted in the size until released. release is idempotent. Acquire counts as a use, so the
+// entry becomes the most recently used; it returns false if key is absent.
+func (c *LRUCache[K, V]) Acquire(key K) (V, func(), bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, found := c.cacheMap[key]
+	if !found {
+		var zeroValue V
+		return zeroValue, func() {}, false
+	}
+	c.moveToFront(entry)
+	entry.hits++
+	entry.refs++
+	epoch := c.epoch
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+
+			entry.refs--
+			if entry.refs > 0 || epoch != c.epoch {
+				return // Still borrowed, or an ImportGob replaced the contents (and reset the counts).
+			}
+			if entry.doomed {
+				// Explicitly removed while borrowed: it has already left the list and map, so all
+				// that's left is to stop counting it.
+				entry.doomed = false
+				c.size--
+				c.deferred--
+			}
+			for c.overCapacity() && c.removeTail() {
+				// Evictions skipped while the entry was borrowed can happen now.
+			}
+		})
+	}
+	return entry.value, release, true
+}
+
+// SnapshotMap returns the cache contents as a plain map. The map is a copy the caller owns, so
+// two snapshots taken at different times can be compared with reflect.DeepEqual or diffed
+// key by key. Recency order is deliberately not part of the snapshot. Values are copied
+// shallowly: if V is a pointer, slice or map, the snapshot shares what it points to.
+func (c *LRUCache[K, V]) SnapshotMap() map[K]V {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	snapshot := make(map[K]V, c.size)
+	for key, entry := range c.cacheMap {
+		snapshot[key] = entry.value
+	}
+	return snapshot
+}
+
+// KeysMatching returns the keys for which pred returns true, ordered from most to least recently
+// used. Listing keys doesn't count as a use, so recency is unchanged. pred runs with the cache
+// locked and must not call back into the cache.
+func (c *LRUCache[K, V]) KeysMatching(pred func(K) bool) []K {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var keys []K
+	for entry := c.head; entry != nil; entry = entry.next {
+		if pred(entry.key) {
+			keys = append(keys, entry.key)
+		}
+	}
+	return keys
+}
+
+// watermarkHysteresis is how far utilization must fall below a watermark before the watermark can
+// fire again. Without it, a cache hovering right at the threshold would fire on every other Put.
+const watermarkHysteresis = 0.05
+
+// watermark is a utilization threshold registered with OnWatermark.
+type watermark struct {
+	threshold float64
+	fn        func()
+	armed     bool // True while utilization is (sufficiently) below threshold.
+}
+
+// OnWatermark registers fn to be called whenever the cache's utilization (size/capacity) rises to
+// or above threshold, e.g. 0.8 for 80%, as a signal for scaling decisions. It fires once per upward
+// crossing: after firing, utilization must drop below threshold - watermarkHysteresis before it
+// can fire again, so a cache hovering around the threshold doesn't flap. fn runs on its own
+// goroutine, so it may safely call back into the cache; if it panics, the panic is logged.
+func (c *LRUCache[K, V]) OnWatermark(threshold float64, fn func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.marks = append(c.marks, &watermark{threshold: threshold, fn: fn, armed: true})
+	c.checkWatermarks() // Fire immediately if the cache is already above the threshold.
+}
+
+// checkWatermarks fires armed watermarks that utilization has reached and re-arms those it has
+// fallen safely below. It must be called with the mutex held, after any change to size or capacity.
+func (c *LRUCache[K, V]) checkWatermarks() {
+	if len(c.marks) == 0 || c.capacity == 0 {
+		return
+	}
+	utilization := float64(c.size-c.deferred) / float64(c.capacity)
+	for _, mark := range c.marks {
+		switch {
+		case mark.armed && utilization >= mark.threshold:
+			mark.armed = false
+			go func(mark *watermark) {
+				// Like the veto hook, a panicking callback is logged rather than allowed to take
+				// down the process (it runs on its own goroutine, so nobody else could recover it).
+				defer func() {
+					if r := recover(); r != nil {
+						log.Printf("cache watermark callback panicked at threshold %v: %v", mark.threshold, r)
+					}
+				}()
+				mark.fn()
+			}(mark)
+		case !mark.armed && utilization < mark.threshold-watermarkHysteresis:
+			mark.armed = true
+		}
+	}
+}
+
+// EvictionPolicy selects how the cache chooses which entry to evict when it is over capacity.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entry. This is the default.
+	EvictLRU EvictionPolicy = iota
+	// EvictWeightedRandom evicts a randomly chosen entry, picking each with probability inversely
+	// proportional to how often it has been read. Frequently read entries are likely to survive
+	// without strict LRU's vulnerability to a scan of one-off keys flushing everything out.
+	EvictWeightedRandom
+)
+
+// valid reports whether p is one of the defined eviction policies.
+func (p EvictionPolicy) valid() bool {
+	return p == EvictLRU || p == EvictWeightedRandom
+}
+
+// SetEvictionPolicy switches the eviction policy. rng is the random source used by
+// EvictWeightedRandom; pass a seeded rand.New(rand.NewSource(seed)) for reproducible evictions,
+// or nil to use a source seeded from the current time. The policy is also part of CacheConfig;
+// this method exists for when a specific random source is needed. It panics on an unknown policy,
+// which could otherwise be stored here only to make a later ApplyConfig(Config()) fail.
+func (c *LRUCache[K, V]) SetEvictionPolicy(policy EvictionPolicy, rng *rand.Rand) {
+	if !policy.valid() {
+		panic(fmt.Sprintf("unknown eviction policy %d", policy))
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	c.policy = policy
+	c.rng = rng
+}
+
+// Priority is an eviction tier. When the cache must evict, it takes the least recently used entry
+// of the lowest tier that has one, so higher tiers survive until every lower tier is exhausted.
+type Priority int
+
+const (
+	// PriorityLow entries are evicted before all others.
+	PriorityLow Priority = iota - 1
+	// PriorityNormal is the tier of entries stored with Put. It is the zero value.
+	PriorityNormal
+	// PriorityHigh entries are evicted only when no low or normal entry can be.
+	PriorityHigh
+)
+
+// PutWithPriority is like Put but also places the entry in priority tier prio, replacing the tier
+// of an existing entry. A later Put keeps the tier. Within a tier the eviction policy applies as
+// usual (LRU by default). Eviction tries the tiers from low to high, so once priorities are in use
+// it may scan the whole list, costing O(n) rather than O(1).
+func (c *LRUCache[K, V]) PutWithPriority(key K, value V, prio Priority) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if prio < PriorityLow || prio > PriorityHigh {
+		panic(fmt.Sprintf("invalid cache priority %d", prio)) // Such an entry could never be evicted.
+	}
+	c.putWithPriority(key, value, prio)
+}
+
+// setPriority moves entry to tier prio, keeping c.tiered in step.
+func (c *LRUCache[K, V]) setPriority(entry *cacheEntry[K, V], prio Priority) {
+	if entry.prio != PriorityNormal {
+		c.tiered--
+	}
+	if prio != PriorityNormal {
+		c.tiered++
+	}
+	entry.prio = prio
+}
+
+// Rekey moves the entry stored under oldKey to newKey, keeping its value, its position in the
+// recency list and its metadata (tags, hit count, insertion order). It reports false and changes
+// nothing if oldKey is absent or newKey is already in use; delete newKey first to overwrite it.
+func (c *LRUCache[K, V]) Rekey(oldKey, newKey K) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, found := c.cacheMap[oldKey]
+	if !found {
+		return false
+	}
+	if oldKey == newKey {
+		return true // Nothing to move.
+	}
+	if _, taken := c.cacheMap[newKey]; taken {
+		return false
+	}
+
+	// The list node stays where it is; only the indexes pointing at it change.
+	delete(c.cacheMap, oldKey)
+	c.cacheMap[newKey] = entry
+	for _, tag := range entry.tags {
+		delete(c.tagIndex[tag], oldKey)
+		c.tagIndex[tag][newKey] = struct{}{}
+	}
+	entry.key = newKey
+	return true
+}
+
+// Fingerprint returns a hash of the cache's contents (keys and values) that doesn't depend on
+// recency or insertion order, so two caches, e.g. replicas, holding the same entries produce the
+// same fingerprint and any difference in content almost certainly changes it. Each entry is hashed
+// from its Go-syntax formatting (%#v), so values compare by content, except that pointers hash by
+// address; the per-entry hashes are then summed, which makes the result order-independent.
+func (c *LRUCache[K, V]) Fingerprint() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var sum uint64
+	hasher := fnv.New64a()
+	for entry := c.head; entry != nil; entry = entry.next {
+		hasher.Reset()
+		fmt.Fprintf(hasher, "%#v\x00%#v", entry.key, entry.value) // NUL keeps key/value boundaries unambiguous.
+		sum += hasher.Sum64()                                     // Addition commutes, so order doesn't matter.
+	}
+	return sum
+}
+
+// Compact rebuilds the underlying map into a freshly allocated one sized for the current contents.
+// Go maps never shrink once they have grown, so after many entries have been removed the old map
+// keeps holding on to its buckets. The linked list (and therefore recency order) is left untouched;
+// only the map that indexes it is replaced, letting the garbage collector reclaim the old buckets.
+func (c *LRUCache[K, V]) Compact() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	compacted := make(map[K]*cacheEntry[K, V], c.size) // Size the new map for exactly what we hold.
+	for entry := c.head; entry != nil; entry = entry.next {
+		compacted[entry.key] = entry // Walk MRU to LRU, re-indexing every live entry.
+	}
+	c.cacheMap = compacted // The old, oversized map becomes garbage.
+}
+
+// ForceReload calls loader for key unconditionally, bypassing whatever the cache currently holds,
+// and stores the fresh value (making it the MRU entry) if the load succeeds. The loader runs
+// without the lock held, so slow loads don't block other cache users; the cache has no
+// in-flight load tracking, so concurrent ForceReloads of the same key each run their own loader
+// and the last one to finish wins. On error the cached value, if any, is left untouched.
+func (c *LRUCache[K, V]) ForceReload(key K, loader func(key K) (V, error)) (V, error) {
+	value, err := safeLoad(key, loader)
+	if err != nil {
+		var zeroValue V
+		return zeroValue, err
+	}
+	c.Put(key, value)
+	return value, nil
+}
+
+// CacheConfig holds the capacity and eviction settings of an LRUCache, for reading and applying
+// them as a unit. The cache has no TTL settings.
+type CacheConfig struct {
+	Capacity          int            // Maximum number of items; 0 disables the cache, negative values are invalid.
+	PreserveOnDisable bool           // Keep the contents aside when disabled and restore them when re-enabled.
+	EvictionPolicy    EvictionPolicy // How victims are chosen; the zero value is EvictLRU.
+}
+
+// Config returns the cache's current configuration.
+func (c *LRUCache[K, V]) Config() CacheConfig {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheConfig{Capacity: c.capacity, PreserveOnDisable: c.preserve, EvictionPolicy: c.policy}
+}
+
+// ApplyConfig validates cfg and applies it atomically. Shrinking the capacity evicts least
+// recently used entries until the cache fits; a capacity of 0 empties the cache (eviction veto or
+// not) and disables it. With PreserveOnDisable set, disabling the cache moves its contents to a
+// spill buffer instead of discarding them, and the next config with a positive capacity restores
+// the most recently used of them (as many as fit) in their original order. Tags and other
+// metadata are not preserved. The eviction policy is switched before any shrinking, so the entries
+// evicted to fit are already chosen by the new policy; EvictWeightedRandom keeps the random source
+// given to SetEvictionPolicy, or gets one seeded from the current time if there is none yet.
+// An invalid config is rejected with an error and leaves the cache unchanged.
+func (c *LRUCache[K, V]) ApplyConfig(cfg CacheConfig) error {
+	if cfg.Capacity < 0 {
+		return errors.New("cache capacity must not be negative")
+	}
+	if !cfg.EvictionPolicy.valid() {
+		return fmt.Errorf("unknown eviction policy %d", cfg.EvictionPolicy)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.policy = cfg.EvictionPolicy
+	if c.policy == EvictWeightedRandom && c.rng == nil {
+		c.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	c.preserve = cfg.PreserveOnDisable
+	if cfg.Capacity == 0 && c.capacity > 0 && cfg.PreserveOnDisable {
+		c.spill = c.spill[:0]
+		for entry := c.head; entry != nil; entry = entry.next {
+			c.spill = append(c.spill, Entry[K, V]{Key: entry.key, Value: entry.value}) // MRU first.
+		}
+	}
+
+	c.capacity = cfg.Capacity
+	if c.capacity == 0 {
+		// A disabled cache must always miss, so everything goes, even entries the eviction veto
+		// would spare: the veto only decides which entry makes room, not whether there is any.
+		for entry := c.tail; entry != nil; {
+			prev := entry.prev // dropEntry unlinks entry.
+			c.dropEntry(entry)
+			c.recordOp("evict", entry.key, "disabled")
+			entry = prev
+		}
+	}
+	for c.overCapacity() && c.removeTail() {
+		// Shrink down to the new capacity, dropping the LRU entries first.
+	}
+
+	if c.capacity > 0 && c.spill != nil {
+		// Re-enabled: bring back the most recent spilled entries that fit, adding the least
+		// recent of them first so the most recent one ends up at the front again.
+		restore := c.spill
+		if len(restore) > c.capacity {
+			restore = restore[:c.capacity]
+		}
+		for i := len(restore) - 1; i >= 0; i-- {
+			if _, found := c.cacheMap[restore[i].Key]; !found {
+				c.put(restore[i].Key, restore[i].Value)
+			}
+		}
+		c.spill = nil
+	}
+	c.checkWatermarks() // The capacity change alone can move utilization across a watermark.
+	return nil
+}
+
+// pendingCreate is the placeholder LoadOrStoreFunc installs for a key while its value is being
+// created. Goroutines that lose the race wait on done and then read value.
+type pendingCreate[V any] struct {
+	done  chan struct{} // Closed once the creator has finished (successfully or not).
+	value V             // The created value; only valid if ok is true.
+	ok    bool          // False if create panicked, in which case waiters must try again.
+}
+
+// LoadOrStoreFunc returns the value cached for key, creating and storing it with create if it is
+// absent. Unlike a Get followed by a Put, it guarantees create runs only once for a contended key:
+// the first caller installs a placeholder and runs create without holding the lock, while any
+// concurrent callers for the same key block until it finishes and receive the same value.
+// The boolean is true if the value was already cached or created by another goroutine, and
+// false if this call created it. If create panics, the panic propagates to its caller and one of
+// the waiting goroutines takes over the creation. After Close, a miss calls create directly and
+// returns its result without storing it.
+func (c *LRUCache[K, V]) LoadOrStoreFunc(key K, create func() V) (V, bool) {
+	for {
+		c.mutex.Lock()
+		if entry, found := c.cacheMap[key]; found {
+			c.moveToFront(entry) // A read counts as a use, just like Get.
+			entry.hits++
+			c.recordOp("get", key, "hit")
+			c.mutex.Unlock()
+			return entry.value, true
+		}
+		if p, found := c.pending[key]; found {
+			// Someone else is already creating this value: wait for them instead of duplicating work.
+			c.mutex.Unlock()
+			<-p.done
+			if p.ok {
+				return p.value, true
+			}
+			continue // The creator panicked; go round again and possibly become the creator.
+		}
+
+		if c.closed {
+			// Shutting down: don't start a load Close would have to wait for.
+			c.mutex.Unlock()
+			return create(), false
+		}
+
+		p := &pendingCreate[V]{done: make(chan struct{})}
+		c.loads.Add(1) // Under the lock, so it can't race with Close starting to wait.
+		if c.pending == nil {
+			c.pending = make(map[K]*pendingCreate[V])
+		}
+		c.pending[key] = p
+		c.mutex.Unlock()
+
+		return c.runCreate(key, p, create), false
+	}
+}
+
+// runCreate runs create for a placeholder installed by LoadOrStoreFunc, stores the result and
+// releases the waiters. The deferred cleanup also runs if create panics, so waiters never hang.
+// The placeholder is only installed for an absent key, so if the key is cached by the time create
+// returns, someone (e.g. Put or ForceReload) wrote a newer value meanwhile. That value is kept and
+// handed to the creator and the waiters instead of being overwritten with the older result.
+func (c *LRUCache[K, V]) runCreate(key K, p *pendingCreate[V], create func() V) (result V) {
+	defer func() {
+		c.mutex.Lock()
+		if p.ok {
+			if entry, found := c.cacheMap[key]; found {
+				p.value = entry.value // Written while we were creating: the newer value wins.
+				result = p.value
+			} else {
+				c.put(key, p.value)
+			}
+		}
+		delete(c.pending, key)
+		c.mutex.Unlock()
+		close(p.done) // Wake every waiter; they check p.ok to see whether creation succeeded.
+		c.loads.Done()
+	}()
+
+	p.value = create() // Runs without the lock, so other keys remain fully usable.
+	p.ok = true
+	return p.value
+}
+
+// Close shuts the cache down gracefully: it stops LoadOrStoreFunc from starting new tracked
+// creations and waits for the ones already in flight to finish and store their values, so no
+// waiter is abandoned. If ctx is done first, Close returns ctx.Err() and the remaining creations
+// keep running in the background. The cache stays readable and writable after Close.
+func (c *LRUCache[K, V]) Close(ctx context.Context) error {
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.loads.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// safeLoad calls loader, converting a panic inside it into an error so that a misbehaving loader
+// can't crash the caller. Loaders always run without the cache lock held, so a panic can never
+// leave the mutex locked; this guard only protects the calling goroutine.
+func safeLoad[K comparable, V any](key K, loader func(key K) (V, error)) (value V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zeroValue V
+			value, err = zeroValue, fmt.Errorf("cache loader panicked: %v", r)
+		}
+	}()
+	return loader(key)
+}
+
+// --- Persistence (encoding/gob) ---
+
+// gobSnapshot is the on-the-wire form of a cache. Its fields are exported because gob only
+// serializes exported fields; the type itself stays private to this file.
+type gobSnapshot[K comparable, V any] struct {
+	Capacity int
+	Entries  []gobEntry[K, V] // Ordered from most to least recently used.
+}
+
+// gobEntry is a single key/value pair inside a gobSnapshot.
+type gobEntry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// ExportGob writes the cache's capacity and contents to w using encoding/gob, in recency order
+// so that ImportGob can rebuild an identical cache. Both K and V must be gob-encodable
+// (interface values need gob.Register). The name avoids GobEncode, whose signature is reserved
+// by the gob.GobEncoder interface.
+func (c *LRUCache[K, V]) ExportGob(w io.Writer) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	snapshot := gobSnapshot[K, V]{
+		Capacity: c.capacity,
+		Entries:  make([]gobEntry[K, V], 0, c.size),
+	}
+	for entry := c.head; entry != nil; entry = entry.next {
+		snapshot.Entries = append(snapshot.Entries, gobEntry[K, V]{Key: entry.key, Value: entry.value})
+	}
+	return gob.NewEncoder(w).Encode(snapshot)
+}
+
+// ImportGob replaces the cache's capacity and contents with a snapshot written by ExportGob,
+// restoring the original recency order. On error the cache is left unchanged.
+func (c *LRUCache[K, V]) ImportGob(r io.Reader) error {
+	var snapshot gobSnapshot[K, V]
+	if err := gob.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err // Decode before taking the lock: it may block on a slow reader.
+	}
+	if snapshot.Capacity < 0 {
+		return errors.New("gob snapshot has a negative capacity")
+	}
+	if snapshot.Capacity == 0 && len(snapshot.Entries) > 0 {
+		// A disabled cache holds nothing, so ExportGob never writes this; importing it could leave
+		// vetoed entries behind in a cache that is supposed to always miss.
+		return errors.New("gob snapshot of a disabled cache has entries")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.capacity = snapshot.Capacity
+	c.cacheMap = make(map[K]*cacheEntry[K, V], len(snapshot.Entries))
+	c.head, c.tail, c.size = nil, nil, 0
+	c.tagIndex = nil // Tags are not part of the snapshot.
+	c.tiered = 0     // Neither are priorities: every imported entry is PriorityNormal.
+	c.spill = nil    // The snapshot replaces anything a preserving disable set aside.
+	c.deferred = 0   // Borrowed entries of the old contents are simply released into the void.
+	c.epoch++        // Makes their releases no-ops.
+	// Entries are stored MRU first, so add them back starting from the LRU end;
+	// each addFront pushes the previous ones further towards the tail.
+	for i := len(snapshot.Entries) - 1; i >= 0; i-- {
+		e := snapshot.Entries[i]
+		if existing, found := c.cacheMap[e.Key]; found {
+			c.deleteEntry(existing) // Tolerate duplicate keys in a hand-crafted stream: the more recent wins.
+		}
+		// Insertion order restarts as LRU to MRU; versions restart at 1, as for any fresh insert.
+		entry := &cacheEntry[K, V]{key: e.Key, value: e.Value, seq: c.nextSeq, ver: 1}
+		c.nextSeq++
+		c.cacheMap[e.Key] = entry
+		c.addFront(entry)
+		c.size++
+	}
+	for c.overCapacity() && c.removeTail() {
+		// Only possible if the stream was tampered with; keep the invariant anyway.
+	}
+	c.checkWatermarks()
+	return nil
+}
+
+// --- Operation Log (for postmortem debugging) ---
+
+// OpRecord describes a single cache operation captured by the operation log.
+type OpRecord[K comparable] struct {
+	Op     string // The operation performed: "get", "put", "remove" or "evict".
+	Key    K      // The key the operation acted on.
+	Result string // The outcome: "hit"/"miss" for gets, "insert"/"update" for puts, "removed"/"evicted" otherwise.
+}
+
+// EnableOpLog turns on recording of the last size cache operations in a fixed-size ring buffer.
+// Once the buffer is full the oldest record is overwritten, so memory use stays bounded.
+// Calling it again discards previously recorded operations; a size <= 0 disables the log,
+// which is the default so that caches that don't need it pay nothing.
+func (c *LRUCache[K, V]) EnableOpLog(size int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if size <= 0 {
+		c.opLog = nil
+	} else {
+		c.opLog = make([]OpRecord[K], size)
+	}
+	c.opNext, c.opCount = 0, 0
+}
+
+// RecentOps returns up to the n most recent operations, oldest first.
+// It returns nil if the operation log is disabled.
+func (c *LRUCache[K, V]) RecentOps(n int) []OpRecord[K] {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if n > c.opCount {
+		n = c.opCount // We can't return more than we've kept.
+	}
+	if n <= 0 {
+		return nil
+	}
+	ops := make([]OpRecord[K], n)
+	start := c.opNext - n // Index of the oldest record we want, possibly wrapping around.
+	for i := range ops {
+		ops[i] = c.opLog[(start+i+len(c.opLog))%len(c.opLog)]
+	}
+	return ops
+}
+
+// recordOp appends an operation to the ring buffer. It must be called with the mutex held
+// and is a no-op when the operation log is disabled.
+func (c *LRUCache[K, V]) recordOp(op string, key K, result string) {
+	if c.opLog == nil {
+		return
+	}
+	c.opLog[c.opNext] = OpRecord[K]{Op: op, Key: key, Result: result}
+	c.opNext = (c.opNext + 1) % len(c.opLog) // Wrap around, overwriting the oldest record.
+	if c.opCount < len(c.opLog) {
+		c.opCount++
+	}
+}
+
+// --- Tagging (bulk invalidation) ---
+
+// PutWithTags stores value under key like Put and attaches the given tags to it, replacing any
+// tags the entry had before. Tags group entries derived from the same source so they can all be
+// dropped together with InvalidateTag. A plain Put on a tagged key keeps its tags.
+func (c *LRUCache[K, V]) PutWithTags(key K, value V, tags ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := c.put(key, value)
+	if entry == nil || c.cacheMap[key] != entry {
+		// Disabled cache, or the new entry was itself chosen as the eviction victim (every older
+		// entry vetoed, a higher priority tier, or a weighted-random draw): nothing to tag.
+		return
+	}
+	c.untag(entry) // Forget the old tag set before recording the new one.
+	if len(tags) == 0 {
+		return
+	}
+	if c.tagIndex == nil {
+		c.tagIndex = make(map[string]map[K]struct{})
+	}
+	entry.tags = append([]string(nil), tags...) // Copy so the caller can reuse its slice.
+	for _, tag := range entry.tags {
+		if c.tagIndex[tag] == nil {
+			c.tagIndex[tag] = make(map[K]struct{})
+		}
+		c.tagIndex[tag][key] = struct{}{}
+	}
+}
+
+// InvalidateTag removes every entry carrying tag and returns how many were removed.
+func (c *LRUCache[K, V]) InvalidateTag(tag string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	keys := c.tagIndex[tag]
+	removed := 0
+	for key := range keys {
+		// deleteEntry updates the tag index (including this set), which is safe while ranging.
+		if entry, found := c.cacheMap[key]; found {
+			c.dropEntry(entry) // Borrowed entries stay counted in size until released.
+			c.recordOp("remove", key, "removed")
+			removed++
+		}
+	}
+	return removed
+}
+
+// untag removes entry from the reverse index of every tag it carries and clears its tags.
+// It must be called with the mutex held.
+func (c *LRUCache[K, V]) untag(entry *cacheEntry[K, V]) {
+	for _, tag := range entry.tags {
+		delete(c.tagIndex[tag], entry.key)
+		if len(c.tagIndex[tag]) == 0 {
+			delete(c.tagIndex, tag) // Don't keep empty sets around for tags nobody uses anymore.
+		}
+	}
+	entry.tags = nil
+}
+
+// --- Access Tracking (keyspace analysis) ---
+
+// The count-min sketch behind access tracking is a fixed sketchDepth x sketchWidth grid of
+// counters, so its memory use doesn't grow with the number of distinct keys.
+const (
+	sketchDepth = 4    // Independent hash rows; more rows mean fewer overestimates.
+	sketchWidth = 1024 // Counters per row; wider rows mean fewer collisions.
+)
+
+// KeyCount is a key together with its (approximate) number of accesses.
+type KeyCount[K comparable] struct {
+	Key   K
+	Count uint64
+}
+
+// accessTracker estimates per-key access frequencies with a count-min sketch and keeps the most
+// frequent keys seen so far in a bounded min-heap. Counts may be overestimated (when keys collide
+// in every row) but never underestimated.
+type accessTracker[K comparable] struct {
+	seeds  [sketchDepth]maphash.Seed        // One hash seed per row.
+	counts [sketchDepth][sketchWidth]uint64 // The sketch's counters.
+	top    keyCountHeap[K]                  // Current top candidates, least frequent at the root.
+	index  map[K]*keyCountItem[K]           // Candidates by key, for O(1) membership checks.
+	limit  int                              // Maximum number of candidates kept.
+}
+
+// EnableAccessTracking starts recording which keys Get is asked for, to analyse the keyspace
+// through TopKeys and DistinctKeys. Memory stays bounded regardless of how many distinct keys are
+// seen: a fixed-size frequency sketch plus at most trackTop candidate keys. Calling it again
+// starts a new observation window; trackTop <= 0 disables tracking, which is the default.
+func (c *LRUCache[K, V]) EnableAccessTracking(trackTop int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if trackTop <= 0 {
+		c.access = nil
+		return
+	}
+	tracker := &accessTracker[K]{
+		index: make(map[K]*keyCountItem[K], trackTop),
+		limit: trackTop,
+	}
+	for i := range tracker.seeds {
+		tracker.seeds[i] = maphash.MakeSeed()
+	}
+	c.access = tracker
+}
+
+// TopKeys returns up to k of the most frequently accessed keys, most frequent first, with their
+// approximate access counts. k is further capped by the trackTop passed to EnableAccessTracking.
+// It returns nil if access tracking is disabled.
+func (c *LRUCache[K, V]) TopKeys(k int) []KeyCount[K] {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.access == nil || k <= 0 {
+		return nil
+	}
+	result := make([]KeyCount[K], len(c.access.top))
+	for i, item := range c.access.top {
+		result[i] = KeyCount[K]{Key: item.key, Count: item.count}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Count > result[j].Count })
+	if k < len(result) {
+		result = result[:k]
+	}
+	return result
+}
+
+// DistinctKeys estimates how many different keys have been accessed since tracking was enabled.
+// It applies linear counting to the first sketch row, so it is accurate while the number of
+// distinct keys is well below sketchWidth and saturates beyond that. Returns 0 if disabled.
+func (c *LRUCache[K, V]) DistinctKeys() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.access == nil {
+		return 0
+	}
+	zeros := 0
+	for _, count := range c.access.counts[0] {
+		if count == 0 {
+			zeros++
+		}
+	}
+	if zeros == 0 {
+		zeros = 1 // The row is saturated; report the largest estimate we can make.
+	}
+	return int(math.Round(-sketchWidth * math.Log(float64(zeros)/sketchWidth)))
+}
+
+// HitCountsTopN returns up to n of the cached keys with the most read hits, most hit first. Every
+// read that finds the entry counts: Get, GetWithVersion, Acquire, LoadOrStoreFunc and
+// SyncMapAdapter's Load and LoadOrStore. Unlike TopKeys these counts are exact and always on: each entry carries its own counter, which
+// starts at zero on insert and disappears with the entry when it is evicted or removed, so memory
+// is bounded by the cache size. Keys with no hits are included, after all keys that have some.
+func (c *LRUCache[K, V]) HitCountsTopN(n int) []KeyCount[K] {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if n <= 0 {
+		return nil
+	}
+	result := make([]KeyCount[K], 0, c.size)
+	for entry := c.head; entry != nil; entry = entry.next {
+		result = append(result, KeyCount[K]{Key: entry.key, Count: entry.hits})
+	}
+	// Stable, so ties keep list order and the more recently used key comes first.
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Count > result[j].Count })
+	if n < len(result) {
+		result = result[:n]
+	}
+	return result
+}
+
+// record counts one access to key and updates the top candidates.
+func (t *accessTracker[K]) record(key K) {
+	// Increment the key's counter in every row; its estimate is the smallest of them,
+	// since collisions can only ever add to a counter.
+	estimate := uint64(math.MaxUint64)
+	for i := range t.counts {
+		cell := maphash.Comparable(t.seeds[i], key) % sketchWidth
+		t.counts[i][cell]++
+		estimate = min(estimate, t.counts[i][cell])
+	}
+
+	switch item, found := t.index[key]; {
+	case found:
+		item.count = estimate
+		heap.Fix(&t.top, item.index)
+	case len(t.top) < t.limit:
+		item := &keyCountItem[K]{key: key, count: estimate}
+		t.index[key] = item
+		heap.Push(&t.top, item)
+	case estimate > t.top[0].count:
+		// The key is now more frequent than the least frequent candidate: replace it.
+		root := t.top[0]
+		delete(t.index, root.key)
+		root.key, root.count = key, estimate
+		t.index[key] = root
+		heap.Fix(&t.top, 0)
+	}
+}
+
+// keyCountItem is a candidate in the top-keys heap.
+type keyCountItem[K comparable] struct {
+	key   K
+	count uint64
+	index int // Position in the heap, maintained by the heap methods for heap.Fix.
+}
+
+// keyCountHeap is a min-heap of candidates ordered by count, implementing heap.Interface.
+type keyCountHeap[K comparable] []*keyCountItem[K]
+
+func (h keyCountHeap[K]) Len() int           { return len(h) }
+func (h keyCountHeap[K]) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h keyCountHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *keyCountHeap[K]) Push(x any) {
+	item := x.(*keyCountItem[K])
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *keyCountHeap[K]) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// --- Versioned Keys ---
+
+// versionedValue is what a VersionedCache stores under each logical key.
+type versionedValue[V any] struct {
+	version int
+	value   V
+}
+
+// VersionedCache is an LRU cache for values that carry a version, such as documents identified by
+// ID and revision. It keeps only the newest known version of each logical key: storing version 2
+// evicts version 1, so stale versions never linger and waste capacity.
+type VersionedCache[V any] struct {
+	cache *LRUCache[string, versionedValue[V]] // One slot per logical key, holding its newest version.
+}
+
+// NewVersionedCache creates a VersionedCache holding up to capacity logical keys.
+func NewVersionedCache[V any](capacity int) *VersionedCache[V] {
+	return &VersionedCache[V]{cache: NewLRUCache[string, versionedValue[V]](capacity)}
+}
+
+// PutVersioned stores value as the given version of logicalKey, replacing (and thereby evicting)
+// any lower version cached for it. A version lower than the cached one is stale and is ignored;
+// the result reports whether the value was stored. Storing the same version again overwrites it.
+func (vc *VersionedCache[V]) PutVersioned(logicalKey string, version int, value V) bool {
+	c := vc.cache
+	c.mutex.Lock() // Hold the lock across the version check and the write so they are atomic.
+	defer c.mutex.Unlock()
+
+	if entry, found := c.cacheMap[logicalKey]; found && entry.value.version > version {
+		return false
 	}
-	oldTailKey := c.tail.key // Store the key of the tail entry before removal.
-	c.remove(c.tail)         // Remove the tail entry from the linked list.
-	delete(c.cacheMap, oldTailKey) // Remove the entry from the map using its key.
-	c.size--                 // Decrement the cache's current size.
+	c.put(logicalKey, versionedValue[V]{version: version, value: value})
+	return true
+}
+
+// GetVersioned returns the newest cached version of logicalKey and its version number.
+func (vc *VersionedCache[V]) GetVersioned(logicalKey string) (V, int, bool) {
+	stored, found := vc.cache.Get(logicalKey)
+	return stored.value, stored.version, found
+}
+
+// --- sync.Map Compatibility ---
+
+// SyncMapAdapter exposes an LRUCache through the method set of sync.Map (Load, Store, LoadOrStore,
+// Delete and Range, all taking any), so code written against sync.Map can switch to a bounded cache
+// by changing only its declaration. Keys and values must have the cache's K and V types; passing
+// anything else panics, just as a mismatched type assertion would.
+type SyncMapAdapter[K comparable, V any] struct {
+	cache *LRUCache[K, V]
+}
+
+// NewSyncMapAdapter wraps cache in a sync.Map-compatible adapter.
+func NewSyncMapAdapter[K comparable, V any](cache *LRUCache[K, V]) *SyncMapAdapter[K, V] {
+	return &SyncMapAdapter[K, V]{cache: cache}
 }
 
+// Load returns the value stored for key, like sync.Map.Load. It counts as a use of the entry.
+func (m *SyncMapAdapter[K, V]) Load(key any) (value any, ok bool) {
+	v, ok := m.cache.Get(key.(K))
+	if !ok {
+		return nil, false // sync.Map returns a nil interface, not a typed zero value, on a miss.
+	}
+	return v, true
+}
+
+// Store sets the value for key, like sync.Map.Store. Unlike sync.Map, this may evict another key.
+func (m *SyncMapAdapter[K, V]) Store(key, value any) {
+	m.cache.Put(key.(K), value.(V))
+}
+
+// LoadOrStore returns the existing value for key if present; otherwise it stores and returns value.
+// loaded is true if the value was loaded, false if stored, like sync.Map.LoadOrStore.
+func (m *SyncMapAdapter[K, V]) LoadOrStore(key, value any) (actual any, loaded bool) {
+	k, v := key.(K), value.(V)
+	c := m.cache
+	c.mutex.Lock() // Check and store in one critical section so concurrent callers agree on the winner.
+	defer c.mutex.Unlock()
+
+	if entry, found := c.cacheMap[k]; found {
+		c.moveToFront(entry)
+		entry.hits++
+		return entry.value, true
+	}
+	c.put(k, v)
+	return v, false
+}
+
+// Delete removes key, like sync.Map.Delete.
+func (m *SyncMapAdapter[K, V]) Delete(key any) {
+	m.cache.RemoveMany([]K{key.(K)})
+}
+
+// Range calls f for each entry, from most to least recently used, until f returns false, like
+// sync.Map.Range. It iterates over a snapshot taken up front, so f may modify the cache freely.
+func (m *SyncMapAdapter[K, V]) Range(f func(key, value any) bool) {
+	c := m.cache
+	c.mutex.Lock()
+	entries := make([]Entry[K, V], 0, c.size)
+	for entry := c.head; entry != nil; entry = entry.next {
+		entries = append(entries, Entry[K, V]{Key: entry.key, Value: entry.value})
+	}
+	c.mutex.Unlock()
+
+	for _, e := range entries {
+		if !f(e.Key, e.Value) {
+			return
+		}
+	}
+}
+
+// --- Key Building ---
+
+// keySeparator joins the parts of a key built by BuildKey.
+const keySeparator = ":"
+
+// keyEscaper escapes the escape character itself first, then the separator, so that no part can
+// produce a bare separator or an ambiguous escape sequence in the joined key.
+var keyEscaper = strings.NewReplacer(`\`, `\\`, keySeparator, `\`+keySeparator)
+
+// noPartsKey is what BuildKey returns for zero parts. Escaping always emits backslashes in pairs or
+// before a separator, so no sequence of parts can produce a lone backslash; in particular it
+// doesn't collide with BuildKey(""), which is the empty string.
+const noPartsKey = `\`
+
+// BuildKey builds a string cache key from several parts (e.g. BuildKey("user", 42, "profile")
+// gives "user:42:profile"). Each part is formatted with fmt.Sprint and any separator or backslash
+// inside it is escaped, so part sequences that would collide under naive joining stay distinct:
+// BuildKey("a:b", "c") is "a\:b:c" while BuildKey("a", "b:c") is "a:b\:c".
+// Parts are told apart by their formatted text only, not their type: BuildKey(1) and
+// BuildKey("1") are the same key, so give parts of different kinds their own positions or prefixes.
+func BuildKey(parts ...any) string {
+	if len(parts) == 0 {
+		return noPartsKey
+	}
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = keyEscaper.Replace(fmt.Sprint(part))
+	}
+	return strings.Join(escaped, keySeparator)
+}
+
+// --- HTTP Response Caching (http.RoundTripper) ---
+
+// cachedResponse is the part of an HTTP response kept in the cache. The body is stored as bytes
+// because a live response body can only be read once.
+type cachedResponse struct {
+	statusCode int
+	status     string
+	header     http.Header
+	body       []byte
+	expires    time.Time   // End of the freshness lifetime the server granted.
+	vary       http.Header // Request values of the headers named by Vary, which must match to reuse.
+}
+
+// CachingRoundTripper is an http.RoundTripper that serves repeated GET requests from an LRUCache.
+// Install it as the Transport of any http.Client and identical GETs (same URL, and the same values
+// for any headers the response lists in Vary) are answered from memory without reaching the
+// network while the response is fresh. It behaves as a shared cache, so it stays on the safe side:
+//   - Requests carrying Authorization or Cookie headers, or "Cache-Control: no-store", always go to
+//     the network, so one user's response is never served to another. "no-cache" skips the lookup.
+//   - Only 200 OK responses with an explicit lifetime (s-maxage, max-age or Expires) are stored,
+//     and only if they aren't marked no-store, no-cache or private, don't set cookies and don't
+//     say "Vary: *". Once that lifetime is over the entry is refetched.
+type CachingRoundTripper struct {
+	base  http.RoundTripper                  // Transport used on a cache miss.
+	cache *LRUCache[string, *cachedResponse] // Responses keyed by full request URL.
+}
+
+// NewCachingRoundTripper wraps base (http.DefaultTransport if nil) with a response cache
+// holding up to capacity responses.
+func NewCachingRoundTripper(base http.RoundTripper, capacity int) *CachingRoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &CachingRoundTripper{
+		base:  base,
+		cache: NewLRUCache[string, *cachedResponse](capacity),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *CachingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqDirectives := cacheControl(req.Header)
+	if req.Method != http.MethodGet || req.Header.Get("Authorization") != "" ||
+		req.Header.Get("Cookie") != "" || hasDirective(reqDirectives, "no-store") {
+		return t.base.RoundTrip(req) // Only plain, anonymous GETs are safe to replay.
+	}
+
+	key := req.URL.String()
+	if !hasDirective(reqDirectives, "no-cache") {
+		if cached, found := t.cache.Get(key); found && cached.usableFor(req) {
+			return cached.toResponse(req), nil
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	expires, storable := freshUntil(resp.Header, time.Now())
+	if !storable {
+		return resp, nil
+	}
+
+	// Read the body once so it can be both cached and handed back to the caller.
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	cached := &cachedResponse{
+		statusCode: resp.StatusCode,
+		status:     resp.Status,
+		header:     resp.Header.Clone(),
+		body:       body,
+		expires:    expires,
+		vary:       make(http.Header),
+	}
+	for _, name := range resp.Header.Values("Vary") {
+		for _, field := range strings.Split(name, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				cached.vary[http.CanonicalHeaderKey(field)] = req.Header.Values(field)
+			}
+		}
+	}
+	t.cache.Put(key, cached) // Replaces a stale entry or one stored for other Vary values.
+	return cached.toResponse(req), nil
+}
+
+// usableFor reports whether the cached response is still fresh and was stored for a request
+// with the same values as req for every header named in Vary.
+func (r *cachedResponse) usableFor(req *http.Request) bool {
+	if !time.Now().Before(r.expires) {
+		return false
+	}
+	for name, values := range r.vary {
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// toResponse builds a fresh *http.Response for req from the cached data. Each call gets its own
+// header copy and body reader, so callers can't interfere with each other or with the cache.
+func (r *cachedResponse) toResponse(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        r.status,
+		StatusCode:    r.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(r.body)),
+		ContentLength: int64(len(r.body)),
+		Request:       req,
+	}
+}
+
+// freshUntil decides whether a shared cache may store a response with the given headers and, if
+// so, until when it stays fresh. s-maxage takes precedence over max-age, which takes precedence
+// over Expires; a response without any of them is not stored, since guessing a lifetime could
+// serve stale data indefinitely.
+func freshUntil(header http.Header, now time.Time) (time.Time, bool) {
+	directives := cacheControl(header)
+	for _, forbidden := range []string{"no-store", "no-cache", "private"} {
+		if hasDirective(directives, forbidden) {
+			return time.Time{}, false
+		}
+	}
+	if header.Get("Set-Cookie") != "" {
+		return time.Time{}, false // The body may be tailored to the session being set up.
+	}
+	for _, name := range header.Values("Vary") {
+		if strings.Contains(name, "*") {
+			return time.Time{}, false // Varies on things a cache can't see.
+		}
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, found := directives[directive]; found {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return time.Time{}, false // Malformed or already stale: don't store.
+			}
+			return now.Add(time.Duration(seconds) * time.Second), true
+		}
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil && expires.After(now) {
+		return expires, true
+	}
+	return time.Time{}, false
+}
+
+// cacheControl parses the Cache-Control header into a map from lower-cased directive name to its
+// value ("" for directives without one, such as no-store).
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// hasDirective reports whether the parsed Cache-Control directives include name.
+func hasDirective(directives map[string]string, name string) bool {
+	_, found := directives[name]
+	return found
+}
 
 // --- Example Usage ---
 // This main function demonstrates how to use the generic, thread-safe LRU cache.
@@ -10,11 +10,25 @@
 package main
 
 import (
-	"encoding/json" // Package for JSON encoding and decoding
-	"fmt"           // Package for formatted I/O (like printing to console)
-	"io/ioutil"     // Package for I/O utility functions, like reading from a reader
-	"net/http"      // Package for HTTP client and server implementations
-	"time"          // Package for time-related functions, used here for setting timeouts
+	"bytes"              // Package for byte slice helpers
+	"context"            // Package for carrying cancellation signals and deadlines across calls
+	"crypto/sha256"      // Package for SHA-256 hashing, used to verify response checksums
+	"crypto/tls"         // Package for TLS, whose handshake state the tracing hooks receive
+	"encoding/hex"       // Package for hex encoding, used to compare checksums with header values
+	"encoding/json"      // Package for JSON encoding and decoding
+	"encoding/xml"       // Package for XML decoding, for APIs that answer in XML
+	"errors"             // Package for creating and inspecting error values
+	"fmt"                // Package for formatted I/O (like printing to console)
+	"io"                 // Package for basic I/O interfaces like Reader and Writer
+	"io/ioutil"          // Package for I/O utility functions, like reading from a reader
+	"mime"               // Package for parsing media types such as Content-Type
+	"mime/multipart"     // Package for reading multipart bodies part by part
+	"net/http"           // Package for HTTP client and server implementations
+	"net/http/httptrace" // Package for hooking into the phases of an HTTP request
+	"strconv"            // Package for converting strings, used here to unquote JSON strings
+	"strings"            // Package for string manipulation helpers
+	"sync"               // Package for synchronization primitives like WaitGroup
+	"time"               // Package for time-related functions, used here for setting timeouts
 )
 
 // Define a struct to represent the structure of the JSON response we expect.
@@ -35,6 +49,9 @@ const apiBaseURL = "https://jsonplaceholder.typicode.com"
 // fetchDataFromAPI makes a GET request to a specified API endpoint and
 // attempts to parse the JSON response into the provided `v` interface.
 // `v` is expected to be a pointer to a struct that matches the JSON structure.
+// Decoding goes through encoding/json, so if `v` (or any field inside it) implements
+// json.Unmarshaler, its UnmarshalJSON method is used instead of reflection; JSONTime below
+// relies on this. The same holds for every Fetch* helper in this file.
 func fetchDataFromAPI(endpoint string, v interface{}) error {
 	// 1. Create a custom HTTP client with a timeout.
 	// This is crucial for production applications to prevent requests
@@ -89,20 +106,1058 @@ func fetchDataFromAPI(endpoint string, v interface{}) error {
 		return fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	// 8. Unmarshal (parse) the JSON data into the provided struct.
+	// 8. Skip decoding if there is nothing to decode.
+	// Endpoints answering 204 No Content (or an empty 200) send no body at all, and
+	// json.Unmarshal would report an error for that. We leave `v` untouched instead.
+	if isEmptyBody(bodyBytes) {
+		return nil
+	}
+
+	// 9. Unmarshal (parse) the JSON data into the provided struct.
 	// `json.Unmarshal` takes the byte slice of JSON data and a pointer
 	// to the Go variable where the data should be stored.
 	err = json.Unmarshal(bodyBytes, v)
 	if err != nil {
 		// If JSON parsing fails, return the error. This often happens if the
-		// struct definition doesn't match the JSON structure.
-		return fmt.Errorf("failed to unmarshal JSON: %w", err)
+		// struct definition doesn't match the JSON structure. The raw body travels
+		// with the error (see DecodeError) so it can be inspected without re-fetching.
+		return &DecodeError{Body: bodyBytes, Err: err}
 	}
 
 	// If all steps were successful, return nil (no error).
 	return nil
 }
 
+// DecodeError is returned when a response body arrived intact but could not be decoded as JSON
+// (or, for the helpers that decode by Content-Type, in the format the server declared).
+// It carries the raw body so callers can log or inspect the malformed payload without having to
+// fetch it again. Use errors.As to get at it; errors.Is/As also see the underlying decoder error.
+type DecodeError struct {
+	Body      []byte // The response body exactly as received.
+	Err       error  // The error reported by the decoder.
+	MediaType string // Format the body was decoded as, e.g. "application/xml"; empty means JSON.
+}
+
+func (e *DecodeError) Error() string {
+	if e.MediaType == "" {
+		return fmt.Sprintf("failed to unmarshal JSON: %v", e.Err)
+	}
+	return fmt.Sprintf("failed to decode %s response: %v", e.MediaType, e.Err)
+}
+
+// Unwrap exposes the underlying decoder error to errors.Is and errors.As.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// doGet sends a GET request for endpoint bound to ctx and returns the response together with its
+// fully read body. Non-2xx status codes are turned into errors, mirroring fetchDataFromAPI.
+// Cancelling ctx aborts the request, which is what lets the helpers below abandon slow requests.
+func doGet(ctx context.Context, endpoint string) (*http.Response, []byte, error) {
+	req, err := newGetRequest(ctx, endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := sendRequest(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if err := checkStatus(resp, bodyBytes); err != nil {
+		return resp, bodyBytes, err
+	}
+	return resp, bodyBytes, nil
+}
+
+// newGetRequest builds a GET request for endpoint bound to ctx, with the same headers
+// fetchDataFromAPI sets. Helpers that need extra headers (e.g. Range) add them before sending.
+func newGetRequest(ctx context.Context, endpoint string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiBaseURL+endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Go-API-Client/1.0")
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// sendRequest executes req with a client using the same timeout as fetchDataFromAPI.
+// The response body is left unread; the caller must close it.
+func sendRequest(req *http.Request) (*http.Response, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second, // Same safety net as fetchDataFromAPI.
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	return resp, nil
+}
+
+// isEmptyBody reports whether a response body has no content to decode, as with 204 No Content.
+// Whitespace-only bodies count as empty, since they can't hold a JSON value either.
+func isEmptyBody(bodyBytes []byte) bool {
+	return len(bytes.TrimSpace(bodyBytes)) == 0
+}
+
+// checkStatus returns an error carrying the status code and body for non-2xx responses.
+func checkStatus(resp *http.Response, bodyBytes []byte) error {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// FetchHedged fetches endpoint like fetchDataFromAPI, but hedges against slow responses:
+// if no response has arrived after hedgeDelay, another identical request is sent, and whichever
+// request succeeds first wins. At most maxHedges extra requests are sent in total, so no more than
+// maxHedges+1 requests are ever in flight. As soon as a winner is found the shared context is
+// cancelled, which aborts the requests that lost the race. Hedging is not retrying: a 4xx answer
+// is returned at once since every copy would get the same one, and once all the requests sent so
+// far have failed the last failure is returned rather than sending more.
+func FetchHedged(endpoint string, v interface{}, hedgeDelay time.Duration, maxHedges int) error {
+	if maxHedges < 0 {
+		return fmt.Errorf("maxHedges must not be negative, got %d", maxHedges)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // Cancels any losing requests once we return.
+
+	// Each request reports back on this channel. It is buffered so that losers never block
+	// trying to send a result nobody is waiting for anymore.
+	type attempt struct {
+		status int // HTTP status code, or 0 if no response arrived.
+		body   []byte
+		err    error
+	}
+	results := make(chan attempt, maxHedges+1)
+
+	launched, inFlight := 0, 0
+	launch := func() {
+		launched++
+		inFlight++
+		go func() {
+			resp, body, err := doGet(ctx, endpoint)
+			res := attempt{body: body, err: err}
+			if resp != nil {
+				res.status = resp.StatusCode
+			}
+			results <- res
+		}()
+	}
+
+	launch() // The primary request.
+	timer := time.NewTimer(hedgeDelay)
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-timer.C:
+			// The requests in flight are taking too long: send a hedge if the budget allows.
+			if launched <= maxHedges {
+				launch()
+				timer.Reset(hedgeDelay)
+			}
+		case res := <-results:
+			inFlight--
+			if res.err == nil {
+				if isEmptyBody(res.body) {
+					return nil // 204 No Content: leave v untouched.
+				}
+				if err := json.Unmarshal(res.body, v); err != nil {
+					return &DecodeError{Body: res.body, Err: err}
+				}
+				return nil
+			}
+			if res.status >= 400 && res.status < 500 {
+				return res.err // The request itself is wrong; the others will be told the same.
+			}
+			lastErr = res.err
+			if inFlight == 0 {
+				return lastErr // Everything sent so far failed; hedges are for slowness, not retries.
+			}
+		}
+	}
+}
+
+// FetchContextWithResponse is the cancellable counterpart of fetchDataFromAPI that also exposes
+// the response metadata. It returns the response headers and status code alongside any error,
+// so callers can inspect things like rate-limit headers even when the request failed with a
+// non-2xx status. If ctx is cancelled or its deadline passes, the returned error wraps ctx.Err(),
+// and errors.Is(err, context.Canceled) (or context.DeadlineExceeded) reports true.
+func FetchContextWithResponse(ctx context.Context, endpoint string, v interface{}) (http.Header, int, error) {
+	resp, bodyBytes, err := doGet(ctx, endpoint)
+	if resp == nil {
+		// The request never produced a response (bad request, network error, cancellation).
+		return nil, 0, err
+	}
+	if err != nil {
+		return resp.Header, resp.StatusCode, err
+	}
+
+	if isEmptyBody(bodyBytes) {
+		return resp.Header, resp.StatusCode, nil // 204 No Content: leave v untouched.
+	}
+	if err := json.Unmarshal(bodyBytes, v); err != nil {
+		return resp.Header, resp.StatusCode, &DecodeError{Body: bodyBytes, Err: err}
+	}
+	return resp.Header, resp.StatusCode, nil
+}
+
+// defaultJSONTimeLayout is the layout JSONTime uses when its Layout field is empty.
+// Change it once at startup if every timestamp in your API shares the same non-RFC3339 format.
+var defaultJSONTimeLayout = "2006-01-02 15:04:05"
+
+// JSONTime wraps time.Time so timestamps in a custom layout can be decoded straight from JSON.
+// The standard time.Time only accepts RFC3339, so a field like "2024-01-02 15:04:05" fails to
+// decode. Use JSONTime as the field type instead; the layout is taken from Layout if set
+// (e.g. on a pre-populated struct), falling back to defaultJSONTimeLayout.
+type JSONTime struct {
+	time.Time
+	Layout string // Go reference-time layout, e.g. "02/01/2006". Empty means defaultJSONTimeLayout.
+}
+
+// UnmarshalJSON parses a quoted timestamp using the configured layout.
+// A JSON null leaves the value untouched, matching encoding/json's convention.
+func (t *JSONTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	raw, err := strconv.Unquote(string(data)) // Timestamps arrive as JSON strings.
+	if err != nil {
+		return fmt.Errorf("JSONTime: expected a quoted string, got %s", data)
+	}
+	parsed, err := time.Parse(t.layout(), raw)
+	if err != nil {
+		return fmt.Errorf("JSONTime: %w", err)
+	}
+	t.Time = parsed
+	return nil
+}
+
+// MarshalJSON formats the time with the same layout, so values round-trip.
+func (t JSONTime) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(t.Time.Format(t.layout()))), nil
+}
+
+// layout returns the layout to use for parsing and formatting.
+func (t JSONTime) layout() string {
+	if t.Layout != "" {
+		return t.Layout
+	}
+	return defaultJSONTimeLayout
+}
+
+// checksumHeader is the response header FetchWithChecksum compares the body against.
+// Its value is the lowercase or uppercase hex encoding of the body's SHA-256 digest.
+const checksumHeader = "X-Content-SHA256"
+
+// ChecksumMismatch is returned by FetchWithChecksum when the body's SHA-256 digest does not match
+// the value advertised in the checksum header.
+type ChecksumMismatch struct {
+	Expected string // Hex digest sent by the server.
+	Actual   string // Hex digest computed over the bytes actually received.
+}
+
+func (e *ChecksumMismatch) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// FetchWithChecksum fetches endpoint and decodes it into v while hashing the body as it streams
+// through the JSON decoder, so the body is never buffered in full. When the response carries an
+// X-Content-SHA256 header, the running digest is compared with it once the body has been consumed
+// and a *ChecksumMismatch is returned on disagreement. Because decoding happens while streaming,
+// v may already be populated when a mismatch is reported; callers must discard it in that case.
+// Responses without the header are decoded without verification.
+func FetchWithChecksum(endpoint string, v interface{}) error {
+	req, err := newGetRequest(context.Background(), endpoint)
+	if err != nil {
+		return err
+	}
+	resp, err := sendRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return checkStatus(resp, bodyBytes)
+	}
+
+	// Every byte the decoder reads also flows through the hasher.
+	hasher := sha256.New()
+	body := io.TeeReader(resp.Body, hasher)
+	if err := json.NewDecoder(body).Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+	// io.EOF above means an empty body (e.g. 204 No Content): v is left untouched, but the digest
+	// is still checked, since a server advertising one for an empty body expects it verified.
+	// The decoder may stop before the end of the body (e.g. trailing whitespace),
+	// so drain the rest to make sure the digest covers every byte.
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	expected := resp.Header.Get(checksumHeader)
+	if expected == "" {
+		return nil // Nothing to verify against.
+	}
+	actual := hex.EncodeToString(hasher.Sum(nil))
+	if !strings.EqualFold(expected, actual) {
+		return &ChecksumMismatch{Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// FetchInto fetches a JSON array from endpoint and decodes it into *dst, reusing the slice's
+// backing array. The slice length is reset to zero first and the decoded elements are appended,
+// so calling FetchInto in a loop with the same slice only allocates when a response has more
+// elements than any before it. Reused elements are zeroed before decoding so fields from a
+// previous response can't leak into the new one.
+func FetchInto[T any](endpoint string, dst *[]T) error {
+	_, bodyBytes, err := doGet(context.Background(), endpoint)
+	if err != nil {
+		return err
+	}
+
+	if isEmptyBody(bodyBytes) {
+		return nil // 204 No Content: leave *dst untouched.
+	}
+	clear((*dst)[:cap(*dst)]) // Zero old elements (json would otherwise merge into them).
+	*dst = (*dst)[:0]         // json.Unmarshal appends to a zero-length slice within its capacity.
+	if err := json.Unmarshal(bodyBytes, dst); err != nil {
+		return &DecodeError{Body: bodyBytes, Err: err}
+	}
+	return nil
+}
+
+// maxConcurrentFetches bounds how many requests FetchConcurrentTyped keeps in flight at once,
+// so a long endpoint list doesn't open hundreds of connections to the same API.
+const maxConcurrentFetches = 4
+
+// FetchConcurrentTyped fetches every endpoint concurrently (at most maxConcurrentFetches at a
+// time) and decodes each response into a T according to its Content-Type. The results are aligned
+// with the input: results[i] and errs[i] belong to endpoints[i], whatever order the requests
+// complete in. A failed endpoint leaves the zero value of T in its slot and does not affect the
+// others. Duplicate endpoints are fetched only once and their result (a shallow copy of the same
+// T) is fanned out to every position that asked for it.
+func FetchConcurrentTyped[T any](endpoints []string) ([]T, []error) {
+	results := make([]T, len(endpoints))
+	errs := make([]error, len(endpoints))
+
+	// Group the positions by endpoint so each distinct endpoint is requested once.
+	positions := make(map[string][]int, len(endpoints))
+	var unique []string // Distinct endpoints in first-seen order.
+	for i, endpoint := range endpoints {
+		if _, seen := positions[endpoint]; !seen {
+			unique = append(unique, endpoint)
+		}
+		positions[endpoint] = append(positions[endpoint], i)
+	}
+
+	sem := make(chan struct{}, maxConcurrentFetches) // Counting semaphore limiting concurrency.
+	var wg sync.WaitGroup
+	for _, endpoint := range unique {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			sem <- struct{}{}        // Acquire a slot...
+			defer func() { <-sem }() // ...and give it back when done.
+
+			value, err := fetchTyped[T](endpoint)
+			// Each goroutine writes only to its own endpoint's indexes, so no locking is needed.
+			for _, i := range positions[endpoint] {
+				results[i], errs[i] = value, err
+			}
+		}(endpoint)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// Decoder decodes a response body into v, which is a pointer. json.Unmarshal and xml.Unmarshal
+// both have this shape.
+type Decoder func(body []byte, v interface{}) error
+
+// decoders maps media types (without parameters such as charset) to the decoder for them.
+// It is guarded by decodersMu because RegisterDecoder may run while fetches are in flight.
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]Decoder{
+		"application/json": json.Unmarshal,
+		"application/xml":  xml.Unmarshal,
+		"text/xml":         xml.Unmarshal,
+	}
+)
+
+// RegisterDecoder makes the typed fetch helpers decode responses whose Content-Type is mediaType
+// (e.g. "application/yaml") with dec, replacing any decoder already registered for it.
+// JSON and XML are registered out of the box.
+func RegisterDecoder(mediaType string, dec Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[strings.ToLower(mediaType)] = dec
+}
+
+// decodeByContentType decodes body into v with the decoder registered for contentType.
+// Structured syntax suffixes are honoured, so application/problem+json decodes as JSON. A missing,
+// malformed or unknown Content-Type falls back to JSON, which is what this API usually speaks.
+// Failures are returned as a *DecodeError naming the media type that was attempted, left empty
+// when it was decoded as JSON, following the convention of every other DecodeError.
+func decodeByContentType(contentType string, body []byte, v interface{}) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/json"
+	}
+
+	format := mediaType // The registry key whose decoder is used.
+	decodersMu.RLock()
+	dec, found := decoders[format]
+	if !found {
+		if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+			format = "application/" + mediaType[i+1:] // e.g. +xml -> application/xml.
+			dec, found = decoders[format]
+		}
+	}
+	decodersMu.RUnlock()
+
+	if !found {
+		format, dec = "application/json", json.Unmarshal
+	}
+	if err := dec(body, v); err != nil {
+		decodeErr := &DecodeError{Body: body, Err: err, MediaType: mediaType}
+		if format == "application/json" {
+			decodeErr.MediaType = "" // JSON failures look the same as from fetchDataFromAPI.
+		}
+		return decodeErr
+	}
+	return nil
+}
+
+// fetchTyped fetches endpoint and decodes the response into a new T, using the decoder registered
+// for the response's Content-Type (see RegisterDecoder). An empty body yields the zero value of T.
+func fetchTyped[T any](endpoint string) (T, error) {
+	var value T
+	resp, bodyBytes, err := doGet(context.Background(), endpoint)
+	if err != nil {
+		return value, err
+	}
+	if isEmptyBody(bodyBytes) {
+		return value, nil
+	}
+	if err := decodeByContentType(resp.Header.Get("Content-Type"), bodyBytes, &value); err != nil {
+		var zeroValue T // Don't hand out a half-decoded value.
+		return zeroValue, err
+	}
+	return value, nil
+}
+
+// ErrRangeIgnored is returned by FetchRange when the server answered a Range request with the
+// whole resource (200 OK) instead of the requested chunk (206 Partial Content). The full body is
+// returned alongside it, so callers that can cope with the complete resource may still use it.
+var ErrRangeIgnored = errors.New("server ignored the Range header and sent the full body")
+
+// FetchRange downloads the bytes start through end (both inclusive, as in the HTTP Range header)
+// of the resource at endpoint. The server must answer 206 Partial Content; a 200 OK means Range
+// isn't supported and yields the full body together with ErrRangeIgnored.
+func FetchRange(endpoint string, start, end int64) ([]byte, error) {
+	chunk, _, err := fetchRange(endpoint, start, end)
+	if err != nil && err != ErrRangeIgnored {
+		return nil, err // Don't hand out a partially read chunk.
+	}
+	return chunk, err
+}
+
+// errRangeNotSatisfiable reports a 416 response: the requested range starts past the end of
+// the resource. FetchResumable uses it to detect that there is nothing left to download.
+var errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// fetchRange implements FetchRange. It additionally returns the total size of the resource taken
+// from the Content-Range header (-1 if the server didn't say), and if the body of a 206 whose
+// Content-Range starts at start is cut off midway, it returns the bytes received so far together
+// with the read error, so callers can resume. Any other failure returns no bytes at all: a
+// truncated error page or a chunk from the wrong offset must never be mistaken for file data.
+func fetchRange(endpoint string, start, end int64) ([]byte, int64, error) {
+	if start < 0 || end < start {
+		return nil, -1, fmt.Errorf("invalid byte range %d-%d", start, end)
+	}
+	req, err := newGetRequest(context.Background(), endpoint)
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	req.Header.Del("Accept") // A chunk of a file isn't JSON.
+
+	resp, err := sendRequest(req)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, readErr := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusPartialContent {
+		first, total := parseContentRange(resp.Header.Get("Content-Range"))
+		if first != start {
+			return nil, -1, fmt.Errorf("server sent a range starting at byte %d, requested %d", first, start)
+		}
+		if readErr != nil {
+			// The bytes that did arrive start where we asked, so they are safe to keep.
+			return bodyBytes, total, fmt.Errorf("failed to read response body: %w", readErr)
+		}
+		return bodyBytes, total, nil
+	}
+	if readErr != nil {
+		return nil, -1, fmt.Errorf("failed to read response body: %w", readErr)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return bodyBytes, int64(len(bodyBytes)), ErrRangeIgnored
+	case http.StatusRequestedRangeNotSatisfiable:
+		_, total := parseContentRange(resp.Header.Get("Content-Range"))
+		return nil, total, errRangeNotSatisfiable
+	default:
+		if err := checkStatus(resp, bodyBytes); err != nil {
+			return nil, -1, err
+		}
+		return nil, -1, fmt.Errorf("expected 206 Partial Content, got status code %d", resp.StatusCode)
+	}
+}
+
+// parseContentRange extracts the first byte position and the complete length from a
+// Content-Range header such as "bytes 0-99/1234" (or "bytes */1234" on a 416). Either is -1 if
+// it is missing, unknown ("*") or malformed.
+func parseContentRange(contentRange string) (first, total int64) {
+	first, total = -1, -1
+	spec := strings.TrimPrefix(contentRange, "bytes ")
+	slash := strings.LastIndex(spec, "/")
+	if slash < 0 {
+		return first, total
+	}
+	if n, err := strconv.ParseInt(spec[slash+1:], 10, 64); err == nil {
+		total = n
+	}
+	if dash := strings.Index(spec[:slash], "-"); dash >= 0 {
+		if n, err := strconv.ParseInt(spec[:dash], 10, 64); err == nil {
+			first = n
+		}
+	}
+	return first, total
+}
+
+// Download tuning for FetchResumable.
+const (
+	resumableChunkSize = 1 << 20 // Bytes requested per Range request (1 MiB).
+	maxResumeAttempts  = 3       // Consecutive failed chunks tolerated before giving up.
+)
+
+// FetchResumable downloads the resource at endpoint into w chunk by chunk using Range requests.
+// If a request fails or the connection drops midway through a chunk, the bytes that did arrive
+// are kept and the download resumes from the first missing byte, giving up only after
+// maxResumeAttempts consecutive failures. Servers that ignore Range are handled by writing the
+// full body they send. The number of bytes written so far is tracked internally; w only needs to
+// support writing at arbitrary offsets, such as an *os.File.
+func FetchResumable(endpoint string, w io.WriterAt) error {
+	var written int64 // Every byte before this offset is safely in w.
+	total := int64(-1)
+	failures := 0
+	for total < 0 || written < total {
+		chunk, size, err := fetchRange(endpoint, written, written+resumableChunkSize-1)
+		if err == ErrRangeIgnored {
+			// The server sent everything at once; just store it.
+			_, err := w.WriteAt(chunk, 0)
+			return err
+		}
+		if err == errRangeNotSatisfiable {
+			// We asked past the end, which means we're done, provided the server's total agrees (or,
+			// if it didn't send one, that we have something: nothing at offset 0 proves nothing).
+			if size == written || (size < 0 && written > 0) {
+				return nil
+			}
+			return fmt.Errorf("download stopped at byte %d: %w", written, err)
+		}
+
+		// Keep whatever arrived, even from a chunk cut off midway (fetchRange only returns bytes
+		// that belong at this offset).
+		if len(chunk) > 0 {
+			if _, werr := w.WriteAt(chunk, written); werr != nil {
+				return werr
+			}
+			written += int64(len(chunk))
+		}
+		if size >= 0 {
+			total = size
+		}
+
+		if err != nil {
+			failures++
+			if failures > maxResumeAttempts {
+				return fmt.Errorf("download interrupted at byte %d: %w", written, err)
+			}
+			continue // Resume from the new offset.
+		}
+		failures = 0
+		if total < 0 && len(chunk) < resumableChunkSize {
+			return nil // Unknown total size: a short chunk means we've reached the end.
+		}
+	}
+	return nil
+}
+
+// SchemaRegistry maps the values of a discriminator field to constructors for the concrete types
+// they identify. Each constructor must return a pointer, e.g. func() any { return &Post{} }.
+type SchemaRegistry map[string]func() any
+
+// FetchByDiscriminator fetches a JSON object from endpoint whose shape depends on the string
+// value of field (for example "type"). It first decodes just that field, looks up the matching
+// constructor in registry and then decodes the whole body into the value it returns.
+// The result is that pointer, ready for a type switch; unknown discriminator values are an error.
+func FetchByDiscriminator(endpoint, field string, registry SchemaRegistry) (any, error) {
+	_, bodyBytes, err := doGet(context.Background(), endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// First pass: peek at the object's fields without decoding them.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(bodyBytes, &fields); err != nil {
+		return nil, &DecodeError{Body: bodyBytes, Err: err}
+	}
+	rawDiscriminator, found := fields[field]
+	if !found {
+		return nil, fmt.Errorf("response has no %q discriminator field", field)
+	}
+	var discriminator string
+	if err := json.Unmarshal(rawDiscriminator, &discriminator); err != nil {
+		return nil, fmt.Errorf("discriminator field %q is not a string: %w", field, err)
+	}
+
+	// Second pass: decode the full body into the registered concrete type.
+	newValue, found := registry[discriminator]
+	if !found {
+		return nil, fmt.Errorf("no schema registered for %s=%q", field, discriminator)
+	}
+	value := newValue()
+	if err := json.Unmarshal(bodyBytes, value); err != nil {
+		return nil, &DecodeError{Body: bodyBytes, Err: err}
+	}
+	return value, nil
+}
+
+// FetchSwitch fetches endpoint and decodes the body into the target registered for the response's
+// status code, for endpoints whose shape depends on the status (e.g. 200 with the finished result
+// versus 202 Accepted with a job reference). Targets must be pointers. It returns the status code;
+// a status without a target is an error, as is any non-2xx status unless a target was registered
+// for it (which allows decoding structured error bodies too).
+func FetchSwitch(endpoint string, targets map[int]interface{}) (int, error) {
+	req, err := newGetRequest(context.Background(), endpoint)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := sendRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	target, found := targets[resp.StatusCode]
+	if !found {
+		if err := checkStatus(resp, bodyBytes); err != nil {
+			return resp.StatusCode, err
+		}
+		return resp.StatusCode, fmt.Errorf("no decode target registered for status code %d", resp.StatusCode)
+	}
+	if isEmptyBody(bodyBytes) {
+		return resp.StatusCode, nil // e.g. 204 No Content: leave the target untouched.
+	}
+	if err := json.Unmarshal(bodyBytes, target); err != nil {
+		return resp.StatusCode, &DecodeError{Body: bodyBytes, Err: err}
+	}
+	return resp.StatusCode, nil
+}
+
+// TokenSource supplies bearer tokens for authenticated requests, e.g. OAuth2 access tokens.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// RefreshingTokenSource is a TokenSource that caches a token until it expires and then fetches a
+// new one through Refresh, for example by calling an OAuth2 token endpoint. It is safe for
+// concurrent use. FetchWithTokenSource calls Invalidate when the server rejects a token early.
+type RefreshingTokenSource struct {
+	Refresh func() (token string, expiresAt time.Time, err error) // Obtains a new token.
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// expirySkew renews tokens slightly before they expire, so a token doesn't run out in transit.
+const expirySkew = 10 * time.Second
+
+// Token returns the cached token, refreshing it first if it is missing or about to expire.
+func (s *RefreshingTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(expirySkew).Before(s.expiresAt) {
+		return s.token, nil
+	}
+	token, expiresAt, err := s.Refresh()
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh token: %w", err)
+	}
+	s.token, s.expiresAt = token, expiresAt
+	return token, nil
+}
+
+// Invalidate discards the cached token so the next Token call refreshes it.
+func (s *RefreshingTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// FetchWithTokenSource fetches endpoint like fetchDataFromAPI, authenticating with a bearer token
+// from ts. If the server answers 401 Unauthorized (the token was revoked or expired early), the
+// token is invalidated when ts supports it (as RefreshingTokenSource does) and the request is
+// retried once with a freshly obtained token.
+func FetchWithTokenSource(endpoint string, v interface{}, ts TokenSource) error {
+	for attempt := 0; ; attempt++ {
+		token, err := ts.Token()
+		if err != nil {
+			return err
+		}
+		req, err := newGetRequest(context.Background(), endpoint)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := sendRequest(req)
+		if err != nil {
+			return err
+		}
+		bodyBytes, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if invalidator, ok := ts.(interface{ Invalidate() }); ok {
+				invalidator.Invalidate()
+			}
+			continue // Try once more with a new token.
+		}
+		if err := checkStatus(resp, bodyBytes); err != nil {
+			return err
+		}
+		if isEmptyBody(bodyBytes) {
+			return nil
+		}
+		if err := json.Unmarshal(bodyBytes, v); err != nil {
+			return &DecodeError{Body: bodyBytes, Err: err}
+		}
+		return nil
+	}
+}
+
+// FetchWithRaw fetches endpoint once and returns both the decoded value and the raw body bytes,
+// e.g. to log or audit exactly what the server sent alongside the typed result. On a decode error
+// the raw bytes are still returned, with the zero value of T. Like FetchConcurrentTyped, it
+// decodes by Content-Type.
+func FetchWithRaw[T any](endpoint string) (T, []byte, error) {
+	var value T
+	resp, bodyBytes, err := doGet(context.Background(), endpoint)
+	if err != nil {
+		return value, bodyBytes, err
+	}
+	if isEmptyBody(bodyBytes) {
+		return value, bodyBytes, nil
+	}
+	if err := decodeByContentType(resp.Header.Get("Content-Type"), bodyBytes, &value); err != nil {
+		var zeroValue T // Don't hand out a half-decoded value; the raw bytes are enough to inspect.
+		return zeroValue, bodyBytes, err
+	}
+	return value, bodyBytes, nil
+}
+
+// ErrSlowFirstByte is returned by FetchWithTTFBTimeout when the server doesn't start responding
+// within the time-to-first-byte limit.
+var ErrSlowFirstByte = errors.New("time to first byte exceeded")
+
+// FetchWithTTFBTimeout fetches endpoint like fetchDataFromAPI, but also fails the request if the
+// first byte of the response hasn't arrived within ttfb of sending it. This catches servers that
+// accept connections but stall before answering much sooner than the overall timeout would, while
+// still giving slow-but-steady downloads the full timeout once data is flowing. Detection uses an
+// httptrace hook, and the returned error wraps ErrSlowFirstByte.
+func FetchWithTTFBTimeout(endpoint string, v interface{}, ttfb time.Duration) error {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	// Arm a timer that aborts the request, and disarm it as soon as the response starts.
+	timer := time.AfterFunc(ttfb, func() { cancel(ErrSlowFirstByte) })
+	defer timer.Stop()
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { timer.Stop() },
+	}
+
+	_, bodyBytes, err := doGet(httptrace.WithClientTrace(ctx, trace), endpoint)
+	if err != nil {
+		if errors.Is(context.Cause(ctx), ErrSlowFirstByte) {
+			return fmt.Errorf("no response from %s within %v: %w", endpoint, ttfb, ErrSlowFirstByte)
+		}
+		return err
+	}
+	if isEmptyBody(bodyBytes) {
+		return nil
+	}
+	if err := json.Unmarshal(bodyBytes, v); err != nil {
+		return &DecodeError{Body: bodyBytes, Err: err}
+	}
+	return nil
+}
+
+// Timings breaks a request's duration down into phases, as captured by FetchWithTrace.
+// Phases that didn't happen (DNS and Connect on a reused connection, TLS for plain HTTP) are zero.
+type Timings struct {
+	DNS     time.Duration // Resolving the host name.
+	Connect time.Duration // Establishing the TCP connection.
+	TLS     time.Duration // The TLS handshake.
+	TTFB    time.Duration // From the start of the request until the first response byte.
+	Total   time.Duration // From the start of the request until the body was fully read.
+}
+
+// FetchWithTrace fetches endpoint like fetchDataFromAPI and reports how long each phase of the
+// request took, using net/http/httptrace hooks. Timings are returned even when the fetch fails,
+// covering whatever phases completed.
+func FetchWithTrace(endpoint string, v interface{}) (Timings, error) {
+	var (
+		mu      sync.Mutex // Hooks may fire on other goroutines (e.g. the dialer), so guard the state.
+		timings Timings
+		started = map[string]time.Time{"request": time.Now()} // When each phase began.
+	)
+	// begin notes the start of a phase; end stores the time elapsed since it began into d.
+	begin := func(phase string) {
+		mu.Lock()
+		defer mu.Unlock()
+		started[phase] = time.Now()
+	}
+	end := func(phase string, d *time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		*d = time.Since(started[phase])
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { begin("dns") },
+		DNSDone:              func(httptrace.DNSDoneInfo) { end("dns", &timings.DNS) },
+		ConnectStart:         func(string, string) { begin("connect") },
+		ConnectDone:          func(string, string, error) { end("connect", &timings.Connect) },
+		TLSHandshakeStart:    func() { begin("tls") },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { end("tls", &timings.TLS) },
+		GotFirstResponseByte: func() { end("request", &timings.TTFB) },
+	}
+
+	_, bodyBytes, err := doGet(httptrace.WithClientTrace(context.Background(), trace), endpoint)
+
+	end("request", &timings.Total)
+	mu.Lock()
+	result := timings // Copy under the lock: a late hook from an abandoned dial could still fire.
+	mu.Unlock()
+
+	if err != nil {
+		return result, err
+	}
+	if isEmptyBody(bodyBytes) {
+		return result, nil
+	}
+	if err := json.Unmarshal(bodyBytes, v); err != nil {
+		return result, &DecodeError{Body: bodyBytes, Err: err}
+	}
+	return result, nil
+}
+
+// FetchConcatenated streams a response made of back-to-back JSON values (no enclosing array and
+// no delimiters, e.g. `{"id":1}{"id":2}` or newline-delimited JSON) and calls fn with each value
+// decoded into a T, in order, until the body ends. Values are decoded one at a time, so the
+// stream can be arbitrarily long. An error from fn stops the stream and is returned as is.
+func FetchConcatenated[T any](ctx context.Context, endpoint string, fn func(T) error) error {
+	req, err := newGetRequest(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	resp, err := sendRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return checkStatus(resp, bodyBytes)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var value T
+		if err := decoder.Decode(&value); err == io.EOF {
+			return nil // Clean end of the stream between two values.
+		} else if err != nil {
+			return fmt.Errorf("failed to unmarshal JSON: %w", err)
+		}
+		if err := fn(value); err != nil {
+			return err
+		}
+	}
+}
+
+// Batcher collects individual requests and sends them upstream in batches, for APIs that offer a
+// bulk endpoint (e.g. fetch many posts by ID in one call). A batch is flushed as soon as it holds
+// maxSize requests or maxWait after its first request arrived, whichever comes first, and each
+// caller receives the response at its own position in the batch.
+type Batcher[Req, Res any] struct {
+	flush   func([]Req) ([]Res, error) // Sends one batch; must return one response per request, in order.
+	maxSize int
+	maxWait time.Duration
+
+	mu      sync.Mutex
+	current *batch[Req, Res] // The batch being filled; nil when none is open.
+}
+
+// batch is one group of requests flushed together.
+type batch[Req, Res any] struct {
+	requests []Req
+	results  []chan batchResult[Res] // One per request, buffered so flushing never blocks.
+}
+
+// batchResult is what a Batcher hands back to an individual caller.
+type batchResult[Res any] struct {
+	value Res
+	err   error
+}
+
+// NewBatcher creates a Batcher that flushes through flush when a batch reaches maxSize requests or
+// has waited maxWait, whichever happens first.
+func NewBatcher[Req, Res any](maxSize int, maxWait time.Duration, flush func([]Req) ([]Res, error)) *Batcher[Req, Res] {
+	if maxSize <= 0 {
+		maxSize = 1 // A batch must be able to hold at least one request.
+	}
+	return &Batcher[Req, Res]{flush: flush, maxSize: maxSize, maxWait: maxWait}
+}
+
+// Do adds req to the current batch and blocks until that batch has been flushed, returning the
+// response for req. If the flush fails, every caller in the batch gets its error.
+func (b *Batcher[Req, Res]) Do(req Req) (Res, error) {
+	result := make(chan batchResult[Res], 1)
+
+	b.mu.Lock()
+	if b.current == nil {
+		// First request of a new batch: start its time window. The timer only flushes
+		// this particular batch, in case it was already flushed for being full.
+		opened := &batch[Req, Res]{}
+		b.current = opened
+		time.AfterFunc(b.maxWait, func() { b.flushIfCurrent(opened) })
+	}
+	full := b.current
+	full.requests = append(full.requests, req)
+	full.results = append(full.results, result)
+	if len(full.requests) < b.maxSize {
+		full = nil // Not full yet: wait for more requests or the timer.
+	} else {
+		b.current = nil // Full: close it so the next request opens a new batch.
+	}
+	b.mu.Unlock()
+
+	if full != nil {
+		go b.run(full)
+	}
+	res := <-result
+	return res.value, res.err
+}
+
+// flushIfCurrent flushes opened when its time window ends, unless it was already flushed.
+func (b *Batcher[Req, Res]) flushIfCurrent(opened *batch[Req, Res]) {
+	b.mu.Lock()
+	if b.current != opened {
+		b.mu.Unlock()
+		return // Already flushed because it filled up.
+	}
+	b.current = nil
+	b.mu.Unlock()
+	b.run(opened)
+}
+
+// run flushes a closed batch and routes each response back to its caller.
+func (b *Batcher[Req, Res]) run(closed *batch[Req, Res]) {
+	responses, err := b.flush(closed.requests)
+	if err == nil && len(responses) != len(closed.requests) {
+		err = fmt.Errorf("batch flush returned %d responses for %d requests", len(responses), len(closed.requests))
+	}
+	for i, result := range closed.results {
+		if err != nil {
+			result <- batchResult[Res]{err: err}
+		} else {
+			result <- batchResult[Res]{value: responses[i]}
+		}
+	}
+}
+
+// FetchMultipart fetches a multipart response (e.g. multipart/mixed) and calls handler with each
+// part in order. The boundary is taken from the response's Content-Type. Parts are streamed: each
+// one is only valid until handler returns, so handler must read what it needs before returning.
+// An error from handler stops the stream and is returned as is.
+func FetchMultipart(endpoint string, handler func(part *multipart.Part) error) error {
+	req, err := newGetRequest(context.Background(), endpoint)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "multipart/mixed") // Replace the default JSON Accept header.
+	resp, err := sendRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return checkStatus(resp, bodyBytes)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("failed to parse Content-Type: %w", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return fmt.Errorf("expected a multipart response with a boundary, got %q", mediaType)
+	}
+
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil // The closing boundary was reached.
+		} else if err != nil {
+			return fmt.Errorf("failed to read multipart part: %w", err)
+		}
+		err = handler(part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
 func main() {
 	fmt.Println("Fetching a single post from the API...")
 